@@ -0,0 +1,183 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// circuitBreaker trips for a single origin host once its failure rate
+// over a rolling window crosses a threshold, short-circuiting further
+// requests to that host for a trip duration instead of letting them
+// stall worker slots on a dead upstream.
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	state    breakerState
+	openedAt time.Time
+	probing  bool
+
+	window []breakerEvent
+
+	failureThreshold float64
+	minSamples       int
+	windowDuration   time.Duration
+	tripDuration     time.Duration
+}
+
+type breakerEvent struct {
+	at      time.Time
+	success bool
+}
+
+func newCircuitBreaker(failureThreshold float64, minSamples int, windowDuration, tripDuration time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: failureThreshold,
+		minSamples:       minSamples,
+		windowDuration:   windowDuration,
+		tripDuration:     tripDuration,
+	}
+}
+
+// Allow reports whether a request to this host may proceed. Open
+// transitions to HalfOpen once the trip duration has elapsed, letting a
+// single probe request through while further callers keep tripping.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.tripDuration {
+			return false
+		}
+
+		b.state = breakerHalfOpen
+		b.probing = false
+
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *circuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.state = breakerClosed
+		b.window = nil
+		return
+	}
+
+	b.record(true)
+}
+
+func (b *circuitBreaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.trip()
+		return
+	}
+
+	b.record(false)
+
+	if b.shouldTrip() {
+		b.trip()
+	}
+}
+
+func (b *circuitBreaker) trip() {
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+	b.window = nil
+}
+
+// record must be called with b.mu held.
+func (b *circuitBreaker) record(success bool) {
+	now := time.Now()
+	b.window = append(b.window, breakerEvent{at: now, success: success})
+
+	cutoff := now.Add(-b.windowDuration)
+
+	i := 0
+	for ; i < len(b.window); i++ {
+		if b.window[i].at.After(cutoff) {
+			break
+		}
+	}
+
+	b.window = b.window[i:]
+}
+
+// shouldTrip must be called with b.mu held.
+func (b *circuitBreaker) shouldTrip() bool {
+	if len(b.window) < b.minSamples {
+		return false
+	}
+
+	failures := 0
+	for _, e := range b.window {
+		if !e.success {
+			failures++
+		}
+	}
+
+	return float64(failures)/float64(len(b.window)) > b.failureThreshold
+}
+
+func (b *circuitBreaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+var circuitBreakers sync.Map // host (string) -> *circuitBreaker
+
+var prometheusBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "download_circuit_breaker_state",
+	Help: "Per-origin circuit breaker state: 0 closed, 1 half-open, 2 open.",
+}, []string{"host"})
+
+// circuitBreakerFor returns the breaker for host, creating it on first use.
+func circuitBreakerFor(host string) *circuitBreaker {
+	if cb, ok := circuitBreakers.Load(host); ok {
+		return cb.(*circuitBreaker)
+	}
+
+	cb := newCircuitBreaker(
+		conf.DownloadBreakerFailureRate,
+		conf.DownloadBreakerMinSamples,
+		time.Duration(conf.DownloadBreakerWindow)*time.Second,
+		time.Duration(conf.DownloadBreakerTripDuration)*time.Second,
+	)
+
+	actual, _ := circuitBreakers.LoadOrStore(host, cb)
+	return actual.(*circuitBreaker)
+}
+
+func reportCircuitBreakerState(host string, cb *circuitBreaker) {
+	if prometheusEnabled {
+		prometheusBreakerState.WithLabelValues(host).Set(float64(cb.State()))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTripsAfterThresholdOverMinSamples(t *testing.T) {
+	b := newCircuitBreaker(0.5, 4, time.Minute, time.Second)
+
+	b.RecordFailure()
+	b.RecordFailure()
+	if b.State() != breakerClosed {
+		t.Fatalf("expected breaker to stay closed below minSamples, got %v", b.State())
+	}
+
+	b.RecordFailure()
+	b.RecordFailure()
+	if b.State() != breakerOpen {
+		t.Fatalf("expected breaker to trip once failure rate crossed threshold over minSamples, got %v", b.State())
+	}
+
+	if b.Allow() {
+		t.Fatal("expected an open breaker to reject requests")
+	}
+}
+
+func TestCircuitBreakerStaysClosedBelowThreshold(t *testing.T) {
+	b := newCircuitBreaker(0.5, 4, time.Minute, time.Second)
+
+	b.RecordFailure()
+	b.RecordSuccess()
+	b.RecordFailure()
+	b.RecordSuccess()
+
+	if b.State() != breakerClosed {
+		t.Fatalf("expected breaker to stay closed with a 50%% failure rate not exceeding the threshold, got %v", b.State())
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbe(t *testing.T) {
+	b := newCircuitBreaker(0.5, 2, time.Minute, 10*time.Millisecond)
+
+	b.RecordFailure()
+	b.RecordFailure()
+	if b.State() != breakerOpen {
+		t.Fatalf("expected breaker to be open, got %v", b.State())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if !b.Allow() {
+		t.Fatal("expected the first probe after tripDuration to be allowed")
+	}
+	if b.Allow() {
+		t.Fatal("expected a second concurrent probe to be rejected while one is already in flight")
+	}
+}
+
+func TestCircuitBreakerHalfOpenSuccessCloses(t *testing.T) {
+	b := newCircuitBreaker(0.5, 2, time.Minute, 10*time.Millisecond)
+
+	b.RecordFailure()
+	b.RecordFailure()
+	time.Sleep(20 * time.Millisecond)
+
+	if !b.Allow() {
+		t.Fatal("expected the probe to be allowed")
+	}
+
+	b.RecordSuccess()
+
+	if b.State() != breakerClosed {
+		t.Fatalf("expected a successful probe to close the breaker, got %v", b.State())
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	b := newCircuitBreaker(0.5, 2, time.Minute, 10*time.Millisecond)
+
+	b.RecordFailure()
+	b.RecordFailure()
+	time.Sleep(20 * time.Millisecond)
+
+	if !b.Allow() {
+		t.Fatal("expected the probe to be allowed")
+	}
+
+	b.RecordFailure()
+
+	if b.State() != breakerOpen {
+		t.Fatalf("expected a failed probe to reopen the breaker, got %v", b.State())
+	}
+}
+
+func TestCircuitBreakerWindowExpiry(t *testing.T) {
+	b := newCircuitBreaker(0.5, 3, 10*time.Millisecond, time.Second)
+
+	b.RecordFailure()
+	b.RecordFailure()
+
+	time.Sleep(20 * time.Millisecond)
+
+	// The two failures above have aged out of the rolling window, so this
+	// third failure leaves only one sample in the window - short of
+	// minSamples - rather than the three it'd take to trip without expiry.
+	b.RecordFailure()
+
+	if b.State() != breakerClosed {
+		t.Fatalf("expected old failures outside the rolling window to not count toward tripping, got %v", b.State())
+	}
+}
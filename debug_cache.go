@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// handleDebugCache serves a snapshot of the source cache's current
+// occupancy and effectiveness, meant to be mounted at /debug/cache
+// alongside the admin server's other /debug/* introspection endpoints.
+func handleDebugCache(w http.ResponseWriter, r *http.Request) {
+	stats := srcCache.Stats()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// registerDebugCache mounts handleDebugCache at /debug/cache on mux. It
+// takes the admin server's own mux rather than registering itself on
+// http.DefaultServeMux via init, since nothing here can confirm that
+// mux is actually what's being served - call this from wherever the
+// admin server wires up its other /debug/* handlers.
+func registerDebugCache(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/cache", handleDebugCache)
+}
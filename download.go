@@ -10,11 +10,16 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
-	"net/http/httputil"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/hashicorp/golang-lru"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+
 	"github.com/imgproxy/imgproxy/v2/imagemeta"
+	"github.com/imgproxy/imgproxy/v2/sourcecache"
 )
 
 var (
@@ -34,7 +39,57 @@ const msgSourceImageIsUnreachable = "Source image is unreachable"
 
 var downloadBufPool *bufPool
 
-var l *lru.ARCCache
+var srcCache sourcecache.SourceCache
+
+// srcCacheConf holds the freshness policy (TTL defaults/clamps, negative
+// caching) applied to every backend srcCache might be composed of.
+var srcCacheConf sourcecache.Config
+
+var (
+	prometheusDownloadsCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "download_coalesced_total",
+		Help: "A counter of downloads that were served by another goroutine's in-flight fetch of the same URL.",
+	})
+	prometheusDownloadsPrimary = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "download_primary_total",
+		Help: "A counter of downloads that performed the actual origin round-trip.",
+	})
+)
+
+// incPrometheusDownloadsCoalesced and incPrometheusDownloadsPrimary count,
+// respectively, downloads that rode in on another goroutine's in-flight
+// fetch versus downloads that performed the actual round-trip. Their ratio
+// is the deduplication ratio operators care about under bursty load.
+func incPrometheusDownloadsCoalesced() { prometheusDownloadsCoalesced.Inc() }
+func incPrometheusDownloadsPrimary()   { prometheusDownloadsPrimary.Inc() }
+
+// downloadGroup coalesces concurrent downloads of the same source URL so
+// that a cold cache under bursty load triggers a single origin fetch.
+var downloadGroup singleflight.Group
+
+// sharedDownload is the value singleflight hands to every caller racing
+// for the same URL. refs tracks how many of them are still holding the
+// decoded imgdata, so its pool buffer is released exactly once.
+type sharedDownload struct {
+	header  http.Header
+	imgdata *imageData
+	refs    int32
+}
+
+// acquire registers one more holder of the shared imgdata and returns the
+// CancelFunc that holder must call when it's done with it.
+func (sd *sharedDownload) acquire() context.CancelFunc {
+	atomic.AddInt32(&sd.refs, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if atomic.AddInt32(&sd.refs, -1) == 0 {
+				sd.imgdata.Close()
+			}
+		})
+	}
+}
 
 type limitReader struct {
 	r    io.Reader
@@ -52,6 +107,25 @@ func (lr *limitReader) Read(p []byte) (n int, err error) {
 	return
 }
 
+// ctxReader refuses any further Read once ctx is done, so a cancelled
+// download stops pulling from a slow-loris source at its next Read instead
+// of holding a download buffer pool slot for the full download timeout. A
+// Read already blocked on r is not interrupted by ctxReader itself.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	select {
+	case <-cr.ctx.Done():
+		return 0, cr.ctx.Err()
+	default:
+	}
+
+	return cr.r.Read(p)
+}
+
 func initDownloading() error {
 	transport := &http.Transport{
 		Proxy:               http.ProxyFromEnvironment,
@@ -93,15 +167,58 @@ func initDownloading() error {
 		}
 	}
 
+	// No client-level Timeout: fetchImage bounds each individual attempt
+	// with its own deadline-derived context instead, so one slow attempt
+	// doesn't by itself consume the whole conf.DownloadTimeout retry
+	// budget and starve every attempt after it.
 	downloadClient = &http.Client{
-		Timeout:   time.Duration(conf.DownloadTimeout) * time.Second,
 		Transport: transport,
 	}
 
-	l, _ = lru.NewARC(500)
+	negativeTTL := make(map[int]time.Duration, len(conf.SourceCacheNegativeTTL))
+	for status, secs := range conf.SourceCacheNegativeTTL {
+		negativeTTL[status] = time.Duration(secs) * time.Second
+	}
+
+	srcCacheConf = sourcecache.Config{
+		DefaultTTL:  time.Duration(conf.SourceCacheDefaultTTL) * time.Second,
+		MinTTL:      time.Duration(conf.SourceCacheMinTTL) * time.Second,
+		MaxTTL:      time.Duration(conf.SourceCacheMaxTTL) * time.Second,
+		NegativeTTL: negativeTTL,
+	}
+
+	l1 := sourcecache.NewMemoryCache(conf.SourceCacheSize)
+
+	janitorInterval := time.Duration(conf.SourceCacheJanitorInterval) * time.Second
+	if janitorInterval <= 0 {
+		janitorInterval = 5 * time.Minute
+	}
+
+	l1.StartJanitor(janitorInterval, srcCacheConf.MaxTTL)
+
+	var l2 sourcecache.SourceCache
+
+	switch conf.SourceCacheBackend {
+	case "filesystem":
+		fsCache, err := sourcecache.NewFilesystemCache(conf.SourceCacheFilesystemRoot)
+		if err != nil {
+			return err
+		}
+
+		fsCache.StartJanitor(janitorInterval, srcCacheConf.MaxTTL)
+		l2 = fsCache
+	case "redis":
+		l2 = sourcecache.NewRedisCache(redis.NewClient(&redis.Options{Addr: conf.SourceCacheRedisURL}), "imgproxy:srccache:")
+	}
+
+	srcCache = sourcecache.NewTiered(l1, l2)
 
 	downloadBufPool = newBufPool("download", conf.Concurrency, conf.DownloadBufferSize)
 
+	if prometheusEnabled {
+		prometheus.MustRegister(prometheusDownloadsCoalesced, prometheusDownloadsPrimary, prometheusBreakerState)
+	}
+
 	imagemeta.SetMaxSvgCheckRead(conf.MaxSvgCheckBytes)
 
 	return nil
@@ -140,80 +257,385 @@ func checkTypeAndDimensions(r io.Reader) (imageType, error) {
 	return imgtype, nil
 }
 
-func readAndCheckImage(r io.Reader, contentLength int) (*imageData, error) {
+// metaPeekSize bounds how much of a source imagemeta.DecodeMeta may look
+// at while readAndCheckImage is still deciding whether the response is
+// even worth buffering.
+const metaPeekSize = 32 * 1024
+
+// ringBufferCapacity bounds how many 32KB chunks the ring buffer's fill
+// goroutine may read ahead of whatever is draining it, so the network
+// read and the drain can run concurrently instead of strictly in
+// lock-step, without letting a fast source run the whole body ahead of
+// a slow drain.
+const ringBufferCapacity = 8
+
+// wrapReadErr turns a body-read error into what the caller should
+// propagate: ctx.Err() unwrapped if the read was aborted by
+// cancellation, the raw error if it's one fetchImage's retry loop
+// should recognize via isRetryableError, or a wrapped downloading error
+// otherwise.
+func wrapReadErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if isRetryableError(err) {
+		return err
+	}
+
+	return newError(404, err.Error(), msgSourceImageIsUnreachable)
+}
+
+// imageTypeNeedsSeek reports whether imgtype's decoder needs random
+// access into its source rather than a single forward pass - SVG may
+// re-scan for embedded references and HEIC's boxes aren't necessarily
+// laid out in decode order. Every other type decodes from a plain
+// forward read, so it's cheaper to land it straight in the download
+// buffer pool than to detour it through a temp file first.
+func imageTypeNeedsSeek(imgtype imageType) bool {
+	return imgtype == imageTypeSVG || imgtype == imageTypeHEIC
+}
+
+// readAndCheckImage validates r's type and dimensions before buffering
+// it. The dimension check runs against a bufio.Reader peek of the header
+// bytes only, so a rejected or oversized source never gets copied into
+// the download buffer pool at all. Only once it's accepted is the rest
+// of r drained, through a ring buffer so the fill goroutine can keep
+// reading off the wire while the drain below copies what's already
+// arrived, instead of the two running strictly sequentially. Every read
+// is cancellation-aware: if ctx is done mid-download, the buffer pool
+// slot (or temp file) is released immediately and ctx.Err() is returned
+// unwrapped so the caller can tell a cancellation apart from an ordinary
+// source error.
+func readAndCheckImage(ctx context.Context, r io.Reader, contentLength int) (*imageData, []byte, error) {
 	if conf.MaxSrcFileSize > 0 && contentLength > conf.MaxSrcFileSize {
-		return nil, errSourceFileTooBig
+		return nil, nil, errSourceFileTooBig
 	}
 
-	buf := downloadBufPool.Get(contentLength)
-	cancel := func() { downloadBufPool.Put(buf) }
+	r = &ctxReader{ctx: ctx, r: r}
 
 	if conf.MaxSrcFileSize > 0 {
 		r = &limitReader{r: r, left: conf.MaxSrcFileSize}
 	}
 
-	imgtype, err := checkTypeAndDimensions(io.TeeReader(r, buf))
+	br := bufio.NewReaderSize(r, metaPeekSize)
+
+	peeked, _ := br.Peek(metaPeekSize)
+
+	imgtype, err := checkTypeAndDimensions(bytes.NewReader(peeked))
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, err
+	}
+
+	rb := newRingBuffer(ringBufferCapacity)
+	go rb.fill(br)
+
+	if conf.MaxSrcFileSize <= 0 && contentLength <= 0 && imageTypeNeedsSeek(imgtype) {
+		return readSeekableTempImage(ctx, rb.reader(), imgtype)
+	}
+
+	buf := downloadBufPool.Get(contentLength)
+	cancel := func() { downloadBufPool.Put(buf) }
+
+	if _, err = buf.ReadFrom(rb.reader()); err != nil {
 		cancel()
-		return nil, err
+		return nil, nil, wrapReadErr(ctx, err)
 	}
 
-	if _, err = buf.ReadFrom(r); err != nil {
+	data := buf.Bytes()
+
+	return &imageData{data, imgtype, cancel}, data, nil
+}
+
+// readSeekableTempImage drains r - an unbounded source with no
+// configured MaxSrcFileSize, for a type whose decoder wants to seek -
+// to a temp file instead of growing an in-memory buffer without limit,
+// so peak RSS for the read itself stays capped at ringBufferCapacity
+// chunks rather than the full body size. imageData is still byte-backed,
+// so the spilled file is read back into the download buffer pool once
+// draining completes; handing the decoder the *os.File directly instead
+// of this final copy would need the decode pipeline to accept an
+// io.ReadSeeker, which is outside this file.
+func readSeekableTempImage(ctx context.Context, r io.Reader, imgtype imageType) (*imageData, []byte, error) {
+	f, cleanupTemp, err := spillToTempFile(r)
+	if err != nil {
+		return nil, nil, wrapReadErr(ctx, err)
+	}
+
+	buf := downloadBufPool.Get(0)
+	cancel := func() {
+		downloadBufPool.Put(buf)
+		cleanupTemp()
+	}
+
+	if _, err = buf.ReadFrom(f); err != nil {
 		cancel()
-		return nil, newError(404, err.Error(), msgSourceImageIsUnreachable)
+		return nil, nil, wrapReadErr(ctx, err)
 	}
 
-	return &imageData{buf.Bytes(), imgtype, cancel}, nil
+	data := buf.Bytes()
+
+	return &imageData{data, imgtype, cancel}, data, nil
 }
 
-func requestImage(imageURL string) (*http.Response, error) {
+// doFetch performs a single HTTP round-trip for imageURL, optionally
+// attaching conditional headers from a stale cache entry being revalidated.
+func doFetch(ctx context.Context, imageURL string, conditional http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", imageURL, nil)
+	if err != nil {
+		return nil, newError(404, err.Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+	}
 
-	if body, _ := l.Get(imageURL); body != nil {
-		fmt.Println(imageURL + " from cache!")
-		r := bufio.NewReader(bytes.NewReader(body.([]byte)))
-		res, err := http.ReadResponse(r, nil)
-		if err != nil {
-			return res, newError(404, err.Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+	req.Header.Set("User-Agent", conf.UserAgent)
+	for name, values := range conditional {
+		for _, v := range values {
+			req.Header.Add(name, v)
 		}
+	}
 
-		if res.StatusCode != 200 {
-			body, _ := ioutil.ReadAll(res.Body)
-			msg := fmt.Sprintf("Can't download image; Status: %d; %s", res.StatusCode, string(body))
-			return res, newError(404, msg, msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+	return downloadClient.Do(req)
+}
+
+// fetchOutcome is fetchImage's fully resolved result for one imageURL:
+// either a freshly read and validated image body, a 304 telling the
+// caller to reuse its cache entry, or a diagnostic body for an
+// uncacheable status. Whichever it is, the origin has given a final
+// answer and nothing about it is worth retrying any further.
+type fetchOutcome struct {
+	header     http.Header
+	statusCode int
+
+	body []byte // diagnostic body, set only for a status other than 200/304
+
+	imgdata *imageData // set only for a 200
+	data    []byte
+}
+
+// fetchOnce performs a single HTTP round-trip plus, for a 200 response,
+// reading and validating its body. retryable reports whether err is
+// worth another attempt: a transient transport error, a retryable
+// status, a body that was cut short mid-read, or this attempt's own
+// bounded ctx hitting its deadline - ctx is only treated as truly fatal
+// when its Err is context.Canceled, since that's the only value that
+// can't also mean "this attempt ran out of its slice of the retry
+// budget, but fetchImage's overall deadline hasn't passed yet".
+func fetchOnce(ctx context.Context, imageURL string, conditional http.Header) (outcome *fetchOutcome, retryable bool, retryAfter time.Duration, hasRetryAfter bool, err error) {
+	res, doErr := doFetch(ctx, imageURL, conditional)
+	if doErr != nil {
+		// Only a true cancellation (the caller's own ctx, not this
+		// attempt's bounded deadline) is fatal here; isRetryableError
+		// already recognizes a deadline-exceeded error via net.Error,
+		// so an attempt timeout falls through to the normal retry path.
+		if ctx.Err() == context.Canceled {
+			return nil, false, 0, false, ctx.Err()
 		}
 
-		return res, nil
-	} else {
-		req, err := http.NewRequest("GET", imageURL, nil)
-		if err != nil {
-			return nil, newError(404, err.Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+		wrapped := newError(404, doErr.Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+		return nil, isRetryableError(doErr), 0, false, wrapped
+	}
+
+	if retryableStatuses[res.StatusCode] {
+		retryAfter, hasRetryAfter = retryAfterDelay(res.Header.Get("Retry-After"))
+		res.Body.Close()
+
+		wrapped := newError(502, fmt.Sprintf("Can't download image; Status: %d", res.StatusCode), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+		return nil, true, retryAfter, hasRetryAfter, wrapped
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		return &fetchOutcome{header: res.Header, statusCode: res.StatusCode}, false, 0, false, nil
+	}
+
+	if res.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(&ctxReader{ctx: ctx, r: res.Body})
+		res.Body.Close()
+
+		if ctx.Err() == context.Canceled {
+			return nil, false, 0, false, ctx.Err()
 		}
 
-		req.Header.Set("User-Agent", conf.UserAgent)
+		if ctx.Err() == context.DeadlineExceeded {
+			wrapped := newError(404, ctx.Err().Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+			return nil, true, 0, false, wrapped
+		}
 
-		res, _ := downloadClient.Do(req)
+		return &fetchOutcome{header: res.Header, statusCode: res.StatusCode, body: body}, false, 0, false, nil
+	}
 
-		body, err := httputil.DumpResponse(res, true)
-		l.Add(imageURL, body)
+	imgdata, data, readErr := readAndCheckImage(ctx, res.Body, int(res.ContentLength))
+	res.Body.Close()
 
-		if err != nil {
-			msg := fmt.Sprintf("Can't dump response")
-			return res, newError(404, msg, msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+	if readErr == nil {
+		return &fetchOutcome{header: res.Header, statusCode: res.StatusCode, imgdata: imgdata, data: data}, false, 0, false, nil
+	}
+
+	if ctx.Err() == context.Canceled {
+		return nil, false, 0, false, ctx.Err()
+	}
+
+	if isRetryableError(readErr) {
+		wrapped := newError(404, readErr.Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+		return nil, true, 0, false, wrapped
+	}
+
+	return nil, false, 0, false, readErr
+}
+
+// fetchImage performs the HTTP round-trip for imageURL, plus reading its
+// body on a 200, retrying transient failures - connection errors,
+// retryable statuses, and a body cut short mid-read - with full-jitter
+// exponential backoff bounded by conf.DownloadTimeout, and
+// short-circuiting to a 502 immediately once the origin host's circuit
+// breaker has tripped. Each attempt runs against its own deadline-bounded
+// ctx so one slow attempt can't by itself consume the whole retry budget.
+// If ctx is done it gives up immediately and returns ctx.Err() unwrapped
+// instead of an ordinary downloading error. downloadImage reaches it only
+// through fetchAndDecode, whose ctx is not the client's, so a client
+// disconnect does not cancel it.
+func fetchImage(ctx context.Context, imageURL string, conditional http.Header) (*fetchOutcome, error) {
+	host := requestHost(imageURL)
+	breaker := circuitBreakerFor(host)
+	defer reportCircuitBreakerState(host, breaker)
+
+	if !breaker.Allow() {
+		return nil, newError(502, fmt.Sprintf("Circuit breaker is open for %s", host), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+	}
+
+	deadline := time.Now().Add(time.Duration(conf.DownloadTimeout) * time.Second)
+
+	for attempt := 0; ; attempt++ {
+		attemptDeadline := deadline
+		if attemptTimeout := time.Duration(conf.DownloadAttemptTimeout) * time.Second; attemptTimeout > 0 {
+			if d := time.Now().Add(attemptTimeout); d.Before(attemptDeadline) {
+				attemptDeadline = d
+			}
 		}
 
-		if err != nil {
-			return res, newError(404, err.Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+		attemptCtx, cancel := context.WithDeadline(ctx, attemptDeadline)
+		outcome, retryable, retryAfter, hasRetryAfter, err := fetchOnce(attemptCtx, imageURL, conditional)
+		cancel()
+
+		if err == nil {
+			breaker.RecordSuccess()
+			return outcome, nil
 		}
 
-		if res.StatusCode != 200 {
-			body, _ := ioutil.ReadAll(res.Body)
-			msg := fmt.Sprintf("Can't download image; Status: %d; %s", res.StatusCode, string(body))
-			return res, newError(404, msg, msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
 
-		return res, nil
+		if !retryable {
+			return nil, err
+		}
+
+		breaker.RecordFailure()
+
+		baseDelay := time.Duration(conf.DownloadRetryBaseDelayMs) * time.Millisecond
+		maxDelay := time.Duration(conf.DownloadRetryMaxDelayMs) * time.Millisecond
+		delay := fullJitterBackoff(baseDelay, attempt, maxDelay)
+		if hasRetryAfter && retryAfter > delay {
+			delay = retryAfter
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
+}
 
+// decodeCached decodes an already-fetched cache Entry's body. A cache
+// entry is just bytes, so this still goes through the usual buffer pool.
+func decodeCached(ctx context.Context, e *sourcecache.Entry) (*imageData, error) {
+	imgdata, _, err := readAndCheckImage(ctx, bytes.NewReader(e.Body), len(e.Body))
+	return imgdata, err
+}
+
+// requestImage resolves imageURL to a decoded image, consulting and
+// populating the source cache. It honors the origin's freshness and
+// revalidation semantics rather than caching responses unconditionally.
+// ctx is threaded all the way down to the body read, so a ctx cancelled
+// mid-fetch releases the download buffer immediately, leaves the cache
+// without the aborted response, and is returned to the caller as
+// ctx.Err() rather than a generic downloading error. Its caller
+// fetchAndDecode passes a ctx bounded only by conf.DownloadTimeout, not
+// the client's: a client disconnect does not stop the fetch, which runs
+// on and still populates the cache.
+func requestImage(ctx context.Context, imageURL string) (*imageData, http.Header, error) {
+	cached, hasCached := srcCache.Get(imageURL)
+
+	if hasCached && cached.Fresh() {
+		imgdata, err := decodeCached(ctx, cached)
+		return imgdata, cached.Header, err
+	}
+
+	var conditional http.Header
+	if hasCached && cached.Revalidatable() {
+		conditional = cached.ConditionalHeaders()
+	}
+
+	res, err := fetchImage(ctx, imageURL, conditional)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if hasCached && res.statusCode == http.StatusNotModified {
+		cached.Refresh(srcCacheConf, res.header)
+		srcCache.Put(imageURL, cached)
+
+		imgdata, err := decodeCached(ctx, cached)
+		return imgdata, cached.Header, err
+	}
+
+	if res.statusCode != 200 {
+		if entry, ok := sourcecache.NewEntry(srcCacheConf, res.statusCode, res.header, res.body); ok {
+			srcCache.Put(imageURL, entry)
+		} else {
+			srcCache.Delete(imageURL)
+		}
+
+		msg := fmt.Sprintf("Can't download image; Status: %d; %s", res.statusCode, string(res.body))
+		return nil, res.header, newError(404, msg, msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+	}
+
+	if entry, ok := sourcecache.NewEntry(srcCacheConf, res.statusCode, res.header, append([]byte(nil), res.data...)); ok {
+		srcCache.Put(imageURL, entry)
+	} else {
+		srcCache.Delete(imageURL)
+	}
+
+	return res.imgdata, res.header, nil
+}
+
+// fetchAndDecode is the function singleflight runs on behalf of every
+// goroutine racing to download the same imageURL; only one of them
+// actually performs the round-trip and the image decode. It deliberately
+// does not use any single waiter's ctx: singleflight runs this closure
+// exactly once per key and hands the same result to every waiter, so
+// tying it to one caller's ctx would let that caller's disconnect cancel
+// (or its lifetime cap the deadline for) a download every other waiter
+// still wants. Instead it gets its own ctx bounded only by
+// conf.DownloadTimeout, and it's each waiter's job in downloadImage to
+// race its own ctx against the shared result.
+func fetchAndDecode(imageURL string) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(conf.DownloadTimeout)*time.Second)
+	defer cancel()
+
+	imgdata, header, err := requestImage(ctx, imageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sharedDownload{header: header, imgdata: imgdata}, nil
 }
 
 func downloadImage(ctx context.Context) (context.Context, context.CancelFunc, error) {
@@ -228,39 +650,62 @@ func downloadImage(ctx context.Context) (context.Context, context.CancelFunc, er
 		defer startPrometheusDuration(prometheusDownloadDuration)()
 	}
 
-	var res *http.Response
+	// primary is set only when this caller's own closure is the one
+	// singleflight runs. Reading it after receiving from resCh is safe:
+	// singleflight sends the result only after the closure has returned.
+	var primary bool
+	resCh := downloadGroup.DoChan(imageURL, func() (interface{}, error) {
+		primary = true
+		return fetchAndDecode(imageURL)
+	})
+
+	var v interface{}
 	var err error
 
-	if cached, _ := l.Get(imageURL); cached != nil {
-		fmt.Println(imageURL + " from cache!")
-		r := bufio.NewReader(bytes.NewReader(cached.([]byte)))
-		res, err = http.ReadResponse(r, nil)
-		if err != nil {
-			return ctx, func() {}, err
-		}
-	} else {
-		res, err = requestImage(imageURL)
-		acache, _ := httputil.DumpResponse(res, true)
-		l.Add(imageURL, acache)
-		if err != nil {
-			return ctx, func() {}, err
-		}
+	// DoChan, not Do: this waiter races its own ctx against the shared
+	// fetch instead of blocking on it unconditionally, so a client
+	// disconnect frees this goroutine's worker slot immediately even
+	// though the download it was waiting on keeps running for whoever
+	// else is still waiting on it.
+	select {
+	case res := <-resCh:
+		v, err = res.Val, res.Err
+	case <-ctx.Done():
+		// resCh is this waiter's own private channel - singleflight
+		// still writes the shared result to it once the in-flight
+		// fetch completes, even though this waiter has stopped
+		// listening. Keep draining it in the background and acquire a
+		// ref just long enough to release it again, so a download every
+		// waiter gave up on still gets its imgdata closed instead of
+		// leaking a download buffer pool slot forever.
+		go func() {
+			if res := <-resCh; res.Err == nil {
+				res.Val.(*sharedDownload).acquire()()
+			}
+		}()
+		return ctx, func() {}, ctx.Err()
 	}
 
-	if res != nil {
-		defer res.Body.Close()
+	if prometheusEnabled {
+		if primary {
+			incPrometheusDownloadsPrimary()
+		} else {
+			incPrometheusDownloadsCoalesced()
+		}
 	}
 
-	imgdata, err := readAndCheckImage(res.Body, int(res.ContentLength))
 	if err != nil {
 		return ctx, func() {}, err
 	}
 
-	ctx = context.WithValue(ctx, imageDataCtxKey, imgdata)
-	ctx = context.WithValue(ctx, cacheControlHeaderCtxKey, res.Header.Get("Cache-Control"))
-	ctx = context.WithValue(ctx, expiresHeaderCtxKey, res.Header.Get("Expires"))
+	sd := v.(*sharedDownload)
+	cancel := sd.acquire()
+
+	ctx = context.WithValue(ctx, imageDataCtxKey, sd.imgdata)
+	ctx = context.WithValue(ctx, cacheControlHeaderCtxKey, sd.header.Get("Cache-Control"))
+	ctx = context.WithValue(ctx, expiresHeaderCtxKey, sd.header.Get("Expires"))
 
-	return ctx, imgdata.Close, err
+	return ctx, cancel, nil
 }
 
 func getImageData(ctx context.Context) *imageData {
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestFetchImageSlowLorisCancel exercises fetchImage against a slow-loris
+// origin (headers sent, body then withheld forever) with an
+// already-cancelled client ctx. It asserts fetchImage returns ctx.Err()
+// immediately rather than waiting on the origin, and that doing so
+// leaves no goroutine - and therefore no held download buffer - behind.
+func TestFetchImageSlowLorisCancel(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-block
+	}))
+	defer srv.Close()
+
+	if err := initDownloading(); err != nil {
+		t.Fatalf("initDownloading: %v", err)
+	}
+
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := fetchImage(ctx, srv.URL, nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fetchImage did not return promptly after ctx was cancelled")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if leaked := runtime.NumGoroutine() - before; leaked > 0 {
+		t.Fatalf("%d goroutines leaked after client disconnect", leaked)
+	}
+}
+
+// TestDownloadGroupWaiterCancelDoesNotAbortSharedFetch proves a waiter
+// racing its own ctx (as downloadImage now does via DoChan) gives up on
+// cancellation without affecting the in-flight singleflight call it was
+// coalesced onto: the shared fetch keeps running to completion for
+// whoever else is still waiting on it.
+func TestDownloadGroupWaiterCancelDoesNotAbortSharedFetch(t *testing.T) {
+	const key = "https://example.test/per-waiter-cancel"
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	resCh := downloadGroup.DoChan(key, func() (interface{}, error) {
+		close(started)
+		<-release
+		return "ok", nil
+	})
+	<-started
+
+	waiterCtx, waiterCancel := context.WithCancel(context.Background())
+	waiterCancel()
+
+	select {
+	case <-resCh:
+		t.Fatal("shared fetch resolved before it was released; test is racy")
+	case <-waiterCtx.Done():
+		// This is the behavior under test: a cancelled waiter returns
+		// immediately instead of blocking on the still-running fetch.
+	}
+
+	close(release)
+
+	res := <-resCh
+	if res.Err != nil || res.Val != "ok" {
+		t.Fatalf("shared fetch should have completed normally despite the other waiter's cancellation, got val=%v err=%v", res.Val, res.Err)
+	}
+}
+
+// TestDownloadGroupAllWaitersCancelStillReleasesSharedImage exercises the
+// exact select/drain downloadImage runs on its ctx.Done() branch (inlined
+// here because downloadImage itself also depends on getImageURL and the
+// newrelic/prometheus hooks, which live outside this file and can't be
+// wired up in a unit test). It proves that when every waiter gives up
+// before the shared fetch completes, the shared imgdata is still
+// acquired-and-released exactly once instead of leaking its download
+// buffer pool slot forever.
+func TestDownloadGroupAllWaitersCancelStillReleasesSharedImage(t *testing.T) {
+	const key = "https://example.test/all-waiters-cancel"
+
+	var released int32
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	resCh := downloadGroup.DoChan(key, func() (interface{}, error) {
+		close(started)
+		<-release
+
+		imgdata := &imageData{nil, imageTypeUnknown, func() { atomic.AddInt32(&released, 1) }}
+		return &sharedDownload{imgdata: imgdata}, nil
+	})
+	<-started
+
+	waiterCtx, waiterCancel := context.WithCancel(context.Background())
+	waiterCancel()
+
+	select {
+	case <-resCh:
+		t.Fatal("shared fetch resolved before it was released; test is racy")
+	case <-waiterCtx.Done():
+		go func() {
+			if res := <-resCh; res.Err == nil {
+				res.Val.(*sharedDownload).acquire()()
+			}
+		}()
+	}
+
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&released) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if atomic.LoadInt32(&released) != 1 {
+		t.Fatalf("shared imgdata was never released after every waiter cancelled; released=%d", released)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// requestHost extracts the host a circuit breaker should be keyed on,
+// falling back to the raw URL if it doesn't parse.
+func requestHost(imageURL string) string {
+	u, err := url.Parse(imageURL)
+	if err != nil || u.Host == "" {
+		return imageURL
+	}
+
+	return u.Host
+}
+
+var retryableStatuses = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// fullJitterBackoff returns a randomized delay for the given (0-based)
+// retry attempt, per the "full jitter" strategy: uniformly random between
+// zero and min(base*2^attempt, max).
+func fullJitterBackoff(base time.Duration, attempt int, max time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+
+	cap := base * (1 << uint(attempt))
+	if cap <= 0 || cap > max {
+		cap = max
+	}
+
+	return time.Duration(rand.Int63n(int64(cap) + 1))
+}
+
+// retryAfterDelay parses a Retry-After header in either delta-seconds or
+// HTTP-date form, per RFC 7231 §7.1.3.
+func retryAfterDelay(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		return time.Until(t), true
+	}
+
+	return 0, false
+}
+
+// isRetryableError reports whether a transport-level error is worth
+// retrying: a body cut short mid-read (io.ErrUnexpectedEOF), or any
+// net.Error. The latter covers connect failures and timeouts, including
+// context.DeadlineExceeded, but also every *url.Error that
+// http.Client.Do returns, whatever its underlying cause.
+func isRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRequestHost(t *testing.T) {
+	if got := requestHost("https://example.com/a/b.jpg"); got != "example.com" {
+		t.Errorf("expected host, got %q", got)
+	}
+
+	if got := requestHost("not a url %%"); got != "not a url %%" {
+		t.Errorf("expected the raw string back for an unparseable URL, got %q", got)
+	}
+}
+
+func TestFullJitterBackoffIsWithinBounds(t *testing.T) {
+	base := 10 * time.Millisecond
+	max := 200 * time.Millisecond
+
+	for attempt := 0; attempt < 10; attempt++ {
+		for i := 0; i < 20; i++ {
+			d := fullJitterBackoff(base, attempt, max)
+			if d < 0 || d > max {
+				t.Fatalf("attempt %d: delay %v out of [0, %v]", attempt, d, max)
+			}
+		}
+	}
+}
+
+func TestFullJitterBackoffCapsAtMax(t *testing.T) {
+	d := fullJitterBackoff(time.Hour, 10, time.Second)
+	if d > time.Second {
+		t.Fatalf("expected delay capped at max, got %v", d)
+	}
+}
+
+func TestRetryAfterDelayDeltaSeconds(t *testing.T) {
+	d, ok := retryAfterDelay("120")
+	if !ok || d != 120*time.Second {
+		t.Errorf("expected 120s, got %v ok=%v", d, ok)
+	}
+}
+
+func TestRetryAfterDelayHTTPDate(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	d, ok := retryAfterDelay(future)
+	if !ok {
+		t.Fatal("expected an HTTP-date Retry-After to parse")
+	}
+	if d <= 55*time.Minute || d > time.Hour {
+		t.Errorf("expected roughly an hour, got %v", d)
+	}
+}
+
+func TestRetryAfterDelayInvalid(t *testing.T) {
+	if _, ok := retryAfterDelay("not a valid value"); ok {
+		t.Error("expected an unparseable Retry-After to report ok=false")
+	}
+
+	if _, ok := retryAfterDelay(""); ok {
+		t.Error("expected an empty Retry-After to report ok=false")
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	if isRetryableError(nil) {
+		t.Error("nil error should not be retryable")
+	}
+
+	if !isRetryableError(io.ErrUnexpectedEOF) {
+		t.Error("a truncated body should be retryable")
+	}
+
+	if !isRetryableError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}) {
+		t.Error("a net.Error should be retryable")
+	}
+
+	if isRetryableError(errors.New("some other error")) {
+		t.Error("an unrecognized error should not be retryable")
+	}
+}
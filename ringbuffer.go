@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ringBuffer is a fixed-capacity pipe of byte chunks. It lets a consumer
+// start draining a source while a producer goroutine is still pulling
+// later chunks off the wire, bounding in-flight memory to capacity
+// chunks instead of the whole source.
+type ringBuffer struct {
+	chunks chan []byte
+	err    error
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{chunks: make(chan []byte, capacity)}
+}
+
+// fill drains src into the ring in fixed-size chunks until EOF or error,
+// then closes the ring. Run it in its own goroutine.
+func (rb *ringBuffer) fill(src io.Reader) {
+	chunk := make([]byte, 32*1024)
+
+	for {
+		n, err := src.Read(chunk)
+		if n > 0 {
+			cp := make([]byte, n)
+			copy(cp, chunk[:n])
+			rb.chunks <- cp
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				rb.err = err
+			}
+			close(rb.chunks)
+			return
+		}
+	}
+}
+
+// reader returns an io.Reader draining the ring in arrival order.
+func (rb *ringBuffer) reader() io.Reader {
+	return &ringBufferReader{rb: rb}
+}
+
+type ringBufferReader struct {
+	rb   *ringBuffer
+	pend []byte
+}
+
+func (r *ringBufferReader) Read(p []byte) (int, error) {
+	for len(r.pend) == 0 {
+		chunk, ok := <-r.rb.chunks
+		if !ok {
+			if r.rb.err != nil {
+				return 0, r.rb.err
+			}
+			return 0, io.EOF
+		}
+		r.pend = chunk
+	}
+
+	n := copy(p, r.pend)
+	r.pend = r.pend[n:]
+
+	return n, nil
+}
+
+// spillToTempFile copies r to a temp file and returns it as an
+// io.ReadSeeker, for processing stages that need a seekable source (some
+// SVG/HEIF paths) and so can't work off the ring buffer's forward-only
+// stream. The caller must invoke the returned cleanup func once done.
+func spillToTempFile(r io.Reader) (io.ReadSeeker, func(), error) {
+	f, err := ioutil.TempFile("", "imgproxy-src-")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	return f, cleanup, nil
+}
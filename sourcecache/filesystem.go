@@ -0,0 +1,254 @@
+package sourcecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FilesystemCache stores each entry's payload in a content-addressed
+// layout (sha256(url), sharded two directories deep) alongside a JSON
+// metadata sidecar carrying the headers and TTL needed to judge
+// freshness without re-reading the body. An in-memory index mirrors the
+// sidecars so lookups don't stat the disk on every request.
+type FilesystemCache struct {
+	root string
+
+	mu    sync.RWMutex
+	index map[string]*fsIndexEntry
+
+	hits   int64
+	misses int64
+}
+
+type fsIndexEntry struct {
+	Path string
+	URL  string
+
+	StatusCode   int
+	Header       http.Header
+	StoredAt     time.Time
+	Deadline     time.Time
+	ETag         string
+	LastModified string
+}
+
+// NewFilesystemCache opens (and, if empty, creates) a filesystem cache
+// rooted at dir, hydrating its in-memory index from any sidecars already
+// on disk from a previous run.
+func NewFilesystemCache(dir string) (*FilesystemCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &FilesystemCache{
+		root:  dir,
+		index: make(map[string]*fsIndexEntry),
+	}
+
+	if err := c.hydrate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func shard(url string) (dir, name string) {
+	sum := sha256.Sum256([]byte(url))
+	hash := hex.EncodeToString(sum[:])
+
+	return filepath.Join(hash[0:2], hash[2:4]), hash
+}
+
+func (c *FilesystemCache) paths(url string) (body, meta string) {
+	dir, name := shard(url)
+	base := filepath.Join(c.root, dir, name)
+
+	return base + ".bin", base + ".json"
+}
+
+func (c *FilesystemCache) hydrate() error {
+	return filepath.Walk(c.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+
+		var idx fsIndexEntry
+		if err := json.Unmarshal(raw, &idx); err != nil {
+			return nil
+		}
+
+		idx.Path = path[:len(path)-len(".json")] + ".bin"
+		c.index[idx.URL] = &idx
+
+		return nil
+	})
+}
+
+func (c *FilesystemCache) Get(url string) (*Entry, bool) {
+	// idx and its body must be read under the same RLock: a concurrent
+	// Put for this url writes a new body to the same deterministic
+	// sha256(url) path and then swaps the index entry, so releasing the
+	// lock between looking up idx and reading idx.Path could pair a
+	// post-swap body with this entry's pre-swap metadata.
+	c.mu.RLock()
+	idx, ok := c.index[url]
+	if !ok {
+		c.mu.RUnlock()
+
+		c.mu.Lock()
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	body, err := ioutil.ReadFile(idx.Path)
+	c.mu.RUnlock()
+
+	if err != nil {
+		c.Delete(url)
+		return nil, false
+	}
+
+	c.mu.Lock()
+	c.hits++
+	c.mu.Unlock()
+
+	return &Entry{
+		Body:         body,
+		Header:       idx.Header,
+		StatusCode:   idx.StatusCode,
+		StoredAt:     idx.StoredAt,
+		Deadline:     idx.Deadline,
+		ETag:         idx.ETag,
+		LastModified: idx.LastModified,
+	}, true
+}
+
+func (c *FilesystemCache) Put(url string, e *Entry) {
+	bodyPath, metaPath := c.paths(url)
+
+	// Held across the file writes too, so a Get under RLock never reads a
+	// body that doesn't belong to the index entry it read alongside it.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(bodyPath), 0755); err != nil {
+		return
+	}
+
+	if err := ioutil.WriteFile(bodyPath, e.Body, 0644); err != nil {
+		return
+	}
+
+	idx := &fsIndexEntry{
+		Path:         bodyPath,
+		URL:          url,
+		StatusCode:   e.StatusCode,
+		Header:       e.Header,
+		StoredAt:     e.StoredAt,
+		Deadline:     e.Deadline,
+		ETag:         e.ETag,
+		LastModified: e.LastModified,
+	}
+
+	raw, err := json.Marshal(idx)
+	if err != nil {
+		return
+	}
+
+	if err := ioutil.WriteFile(metaPath, raw, 0644); err != nil {
+		return
+	}
+
+	c.index[url] = idx
+}
+
+func (c *FilesystemCache) Delete(url string) {
+	c.mu.Lock()
+	idx, ok := c.index[url]
+	delete(c.index, url)
+	c.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	os.Remove(idx.Path)
+	os.Remove(idx.Path[:len(idx.Path)-len(".bin")] + ".json")
+}
+
+func (c *FilesystemCache) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	top := make([]URLStat, 0, 10)
+	var bytes int64
+
+	for url, idx := range c.index {
+		if info, err := os.Stat(idx.Path); err == nil {
+			bytes += info.Size()
+			if len(top) < 10 {
+				top = append(top, URLStat{URL: url, Size: int(info.Size())})
+			}
+		}
+	}
+
+	return Stats{
+		Bytes:   bytes,
+		Count:   len(c.index),
+		Hits:    c.hits,
+		Misses:  c.misses,
+		TopURLs: top,
+	}
+}
+
+// Sweep removes entries whose freshness deadline passed more than
+// retention ago, run periodically by a janitor goroutine.
+func (c *FilesystemCache) Sweep(retention time.Duration) {
+	cutoff := time.Now().Add(-retention)
+
+	c.mu.RLock()
+	stale := make([]string, 0)
+	for url, idx := range c.index {
+		if idx.Deadline.Before(cutoff) {
+			stale = append(stale, url)
+		}
+	}
+	c.mu.RUnlock()
+
+	for _, url := range stale {
+		c.Delete(url)
+	}
+}
+
+// StartJanitor runs Sweep every interval until the returned func is called.
+func (c *FilesystemCache) StartJanitor(interval, retention time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				c.Sweep(retention)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,49 @@
+package sourcecache
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFilesystemCacheGetPutDelete(t *testing.T) {
+	c, err := NewFilesystemCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemCache: %v", err)
+	}
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected miss on empty cache")
+	}
+
+	c.Put("a", &Entry{Body: []byte("hello"), Header: http.Header{}})
+
+	e, ok := c.Get("a")
+	if !ok || string(e.Body) != "hello" {
+		t.Fatalf("expected to get back what was put, got %v ok=%v", e, ok)
+	}
+
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected miss after delete")
+	}
+}
+
+func TestFilesystemCacheHydratesFromDisk(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := NewFilesystemCache(dir)
+	if err != nil {
+		t.Fatalf("NewFilesystemCache: %v", err)
+	}
+	c.Put("a", &Entry{Body: []byte("hello"), Header: http.Header{}})
+
+	reopened, err := NewFilesystemCache(dir)
+	if err != nil {
+		t.Fatalf("NewFilesystemCache (reopen): %v", err)
+	}
+
+	e, ok := reopened.Get("a")
+	if !ok || string(e.Body) != "hello" {
+		t.Fatalf("expected reopened cache to hydrate its index from disk, got %v ok=%v", e, ok)
+	}
+}
@@ -0,0 +1,163 @@
+package sourcecache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryCache is an in-memory SourceCache bounded by total resident
+// bytes rather than entry count, evicting the least recently used
+// entries once MaxBytes is exceeded. A MaxBytes of 0 means unbounded.
+type MemoryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	curBytes int64
+	ll       *list.List
+	items    map[string]*list.Element
+
+	hits   int64
+	misses int64
+}
+
+type memoryItem struct {
+	url   string
+	entry *Entry
+}
+
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *MemoryCache) Get(url string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[url]
+	if !ok {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+
+	atomic.AddInt64(&c.hits, 1)
+	c.ll.MoveToFront(el)
+
+	// Hand back a copy, not the list's own *Entry: callers may mutate it
+	// (Entry.Refresh on a 304) with no lock held, which would otherwise
+	// race sweepExpired reading the very same entry's Deadline under c.mu.
+	cp := *el.Value.(*memoryItem).entry
+	return &cp, true
+}
+
+func (c *MemoryCache) Put(url string, e *Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[url]; ok {
+		c.curBytes -= int64(len(el.Value.(*memoryItem).entry.Body))
+		el.Value.(*memoryItem).entry = e
+		c.ll.MoveToFront(el)
+	} else {
+		el := c.ll.PushFront(&memoryItem{url: url, entry: e})
+		c.items[url] = el
+	}
+
+	c.curBytes += int64(len(e.Body))
+
+	c.evict()
+}
+
+func (c *MemoryCache) Delete(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[url]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *MemoryCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	top := make([]URLStat, 0, 10)
+	for el := c.ll.Front(); el != nil && len(top) < 10; el = el.Next() {
+		it := el.Value.(*memoryItem)
+		top = append(top, URLStat{URL: it.url, Size: len(it.entry.Body)})
+	}
+
+	return Stats{
+		Bytes:   c.curBytes,
+		Count:   len(c.items),
+		Hits:    atomic.LoadInt64(&c.hits),
+		Misses:  atomic.LoadInt64(&c.misses),
+		TopURLs: top,
+	}
+}
+
+// evict drops least-recently-used entries until curBytes is back under
+// maxBytes. Caller must hold c.mu.
+func (c *MemoryCache) evict() {
+	for c.maxBytes > 0 && c.curBytes > c.maxBytes {
+		el := c.ll.Back()
+		if el == nil {
+			break
+		}
+
+		c.removeElement(el)
+	}
+}
+
+// removeElement must be called with c.mu held.
+func (c *MemoryCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+
+	it := el.Value.(*memoryItem)
+	delete(c.items, it.url)
+	c.curBytes -= int64(len(it.entry.Body))
+}
+
+// sweepExpired drops entries that went stale more than retention ago.
+func (c *MemoryCache) sweepExpired(retention time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+
+		if el.Value.(*memoryItem).entry.Deadline.Before(cutoff) {
+			c.removeElement(el)
+		}
+
+		el = next
+	}
+}
+
+// StartJanitor runs a background sweep of long-expired entries every
+// interval, so a cold entry's memory isn't pinned until something
+// happens to look it up again. Call the returned func to stop it.
+func (c *MemoryCache) StartJanitor(interval, retention time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				c.sweepExpired(retention)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,62 @@
+package sourcecache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheGetPutDelete(t *testing.T) {
+	c := NewMemoryCache(0)
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected miss on empty cache")
+	}
+
+	c.Put("a", &Entry{Body: []byte("hello")})
+
+	e, ok := c.Get("a")
+	if !ok || string(e.Body) != "hello" {
+		t.Fatalf("expected to get back what was put, got %v ok=%v", e, ok)
+	}
+
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected miss after delete")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("unexpected hit/miss counts: %+v", stats)
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewMemoryCache(10)
+
+	c.Put("a", &Entry{Body: make([]byte, 6)})
+	c.Put("b", &Entry{Body: make([]byte, 6)})
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected \"a\" to have been evicted once curBytes exceeded maxBytes")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Fatal("expected \"b\" to survive eviction as the most recently used entry")
+	}
+}
+
+func TestMemoryCacheGetReturnsACopyNotTheLiveEntry(t *testing.T) {
+	c := NewMemoryCache(0)
+	c.Put("a", &Entry{Body: []byte("hello")})
+
+	got, ok := c.Get("a")
+	if !ok {
+		t.Fatal("expected hit")
+	}
+
+	got.Deadline = got.Deadline.Add(time.Hour)
+
+	el := c.items["a"]
+	if el.Value.(*memoryItem).entry == got {
+		t.Fatal("Get must hand back a copy, not the list's own *Entry, so unlocked caller mutation can't race sweepExpired")
+	}
+}
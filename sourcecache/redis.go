@@ -0,0 +1,110 @@
+package sourcecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisCache is a SourceCache backend storing each entry's body under a
+// TTL'd string key, and its freshness/header metadata in a companion
+// hash with the same TTL, so a restart doesn't cold-start the cache.
+type RedisCache struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisCache(client *redis.Client, prefix string) *RedisCache {
+	return &RedisCache{client: client, prefix: prefix}
+}
+
+func (c *RedisCache) bodyKey(url string) string { return c.prefix + "body:" + url }
+func (c *RedisCache) metaKey(url string) string { return c.prefix + "meta:" + url }
+
+func (c *RedisCache) Get(url string) (*Entry, bool) {
+	ctx := context.Background()
+
+	meta, err := c.client.HGetAll(ctx, c.metaKey(url)).Result()
+	if err != nil || len(meta) == 0 {
+		return nil, false
+	}
+
+	body, err := c.client.Get(ctx, c.bodyKey(url)).Bytes()
+	if err != nil {
+		return nil, false
+	}
+
+	var header http.Header
+	if err := json.Unmarshal([]byte(meta["headers"]), &header); err != nil {
+		return nil, false
+	}
+
+	status, _ := strconv.Atoi(meta["status"])
+	storedAt, _ := strconv.ParseInt(meta["stored_at"], 10, 64)
+	deadline, _ := strconv.ParseInt(meta["deadline"], 10, 64)
+
+	return &Entry{
+		Body:         body,
+		Header:       header,
+		StatusCode:   status,
+		StoredAt:     time.Unix(storedAt, 0),
+		Deadline:     time.Unix(deadline, 0),
+		ETag:         meta["etag"],
+		LastModified: meta["last_modified"],
+	}, true
+}
+
+// revalidationOnlyTTL bounds how long an entry with no positive freshness
+// window (must-revalidate/no-cache, which freshnessTTL deliberately
+// reports as ttl=0, ok=true) is kept in Redis so it can still serve as
+// the basis for a conditional revalidation request. Without this, a
+// literal zero TTL would make Redis expire the key immediately, silently
+// losing the ETag/Last-Modified this class of response is cached for.
+const revalidationOnlyTTL = 24 * time.Hour
+
+func (c *RedisCache) Put(url string, e *Entry) {
+	ttl := time.Until(e.Deadline)
+	if ttl <= 0 {
+		if !e.Revalidatable() {
+			return
+		}
+		ttl = revalidationOnlyTTL
+	}
+
+	headerJSON, err := json.Marshal(e.Header)
+	if err != nil {
+		return
+	}
+
+	ctx := context.Background()
+
+	pipe := c.client.TxPipeline()
+	pipe.Set(ctx, c.bodyKey(url), e.Body, ttl)
+	pipe.HSet(ctx, c.metaKey(url), map[string]interface{}{
+		"status":        e.StatusCode,
+		"stored_at":     e.StoredAt.Unix(),
+		"deadline":      e.Deadline.Unix(),
+		"etag":          e.ETag,
+		"last_modified": e.LastModified,
+		"headers":       string(headerJSON),
+	})
+	pipe.Expire(ctx, c.metaKey(url), ttl)
+
+	pipe.Exec(ctx)
+}
+
+func (c *RedisCache) Delete(url string) {
+	ctx := context.Background()
+	c.client.Del(ctx, c.bodyKey(url), c.metaKey(url))
+}
+
+// Stats returns a zero Stats: RedisCache keeps no hit/miss counters and
+// does not scan keys for size or count. Redis's own INFO/MEMORY commands
+// cover that for operators.
+func (c *RedisCache) Stats() Stats {
+	return Stats{}
+}
@@ -0,0 +1,271 @@
+// Package sourcecache caches fetched source images using real HTTP
+// freshness semantics instead of caching responses forever. An Entry
+// knows when it goes stale and what it needs (ETag/Last-Modified) to be
+// revalidated with a conditional request rather than refetched whole.
+package sourcecache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a single cached HTTP response: its body plus the header
+// metadata needed to judge freshness and perform conditional revalidation.
+type Entry struct {
+	Body       []byte
+	Header     http.Header
+	StatusCode int
+
+	StoredAt time.Time
+	Deadline time.Time
+
+	ETag         string
+	LastModified string
+}
+
+// Fresh reports whether the entry can still be served without revalidation.
+func (e *Entry) Fresh() bool {
+	return time.Now().Before(e.Deadline)
+}
+
+// Revalidatable reports whether a stale entry carries enough information
+// to attempt a conditional GET instead of a full refetch.
+func (e *Entry) Revalidatable() bool {
+	return e.ETag != "" || e.LastModified != ""
+}
+
+// Refresh extends a revalidated entry's TTL after a 304 response and
+// adopts the ETag and Last-Modified validators if the origin resent them.
+// Other headers of the 304 only feed the TTL; e.Header is left unchanged.
+func (e *Entry) Refresh(conf Config, header http.Header) {
+	if ttl, ok := freshnessTTL(conf, e.StatusCode, header); ok {
+		e.StoredAt = time.Now()
+		e.Deadline = e.StoredAt.Add(ttl)
+	}
+
+	if etag := header.Get("ETag"); etag != "" {
+		e.ETag = etag
+	}
+
+	if lm := header.Get("Last-Modified"); lm != "" {
+		e.LastModified = lm
+	}
+}
+
+// Config controls freshness defaults applied when the origin doesn't
+// provide enough caching information of its own.
+type Config struct {
+	DefaultTTL time.Duration
+	MinTTL     time.Duration
+	MaxTTL     time.Duration
+
+	// NegativeTTL maps an HTTP status code to how long an error response
+	// for it should be cached, shielding the origin from repeated misses.
+	// A status code with no entry here is never cached.
+	NegativeTTL map[int]time.Duration
+}
+
+// URLStat is a single row of Stats.TopURLs.
+type URLStat struct {
+	URL  string
+	Size int
+}
+
+// Stats is a point-in-time snapshot of a SourceCache's occupancy and
+// effectiveness, returned to the `/debug/cache` admin endpoint.
+type Stats struct {
+	Bytes  int64
+	Count  int
+	Hits   int64
+	Misses int64
+
+	TopURLs []URLStat
+}
+
+// SourceCache is a cache of Entry keyed by the canonicalized source URL.
+// Implementations (MemoryCache, FilesystemCache, RedisCache, Tiered) are
+// safe for concurrent use.
+type SourceCache interface {
+	Get(url string) (*Entry, bool)
+	Put(url string, e *Entry)
+	Delete(url string)
+	Stats() Stats
+}
+
+// NewEntry builds an Entry from a freshly fetched response, computing its
+// freshness deadline from the response's caching headers. ok is false
+// when the response must not be cached at all (no-store/private, Vary: *,
+// or an error status with no configured negative TTL).
+func NewEntry(conf Config, statusCode int, header http.Header, body []byte) (entry *Entry, ok bool) {
+	cc := parseCacheControl(header.Get("Cache-Control"))
+
+	if cc.noStore || cc.private || varyIsWild(header) {
+		return nil, false
+	}
+
+	ttl, ok := freshnessTTL(conf, statusCode, header)
+	if !ok {
+		return nil, false
+	}
+
+	now := time.Now()
+
+	return &Entry{
+		Body:         body,
+		Header:       header,
+		StatusCode:   statusCode,
+		StoredAt:     now,
+		Deadline:     now.Add(ttl),
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	}, true
+}
+
+// ConditionalHeaders returns the If-None-Match/If-Modified-Since pair to
+// send when revalidating a stale entry.
+func (e *Entry) ConditionalHeaders() http.Header {
+	h := make(http.Header)
+
+	if e.ETag != "" {
+		h.Set("If-None-Match", e.ETag)
+	}
+
+	if e.LastModified != "" {
+		h.Set("If-Modified-Since", e.LastModified)
+	}
+
+	return h
+}
+
+type cacheControl struct {
+	noStore        bool
+	noCache        bool
+	private        bool
+	mustRevalidate bool
+
+	maxAge    time.Duration
+	hasMaxAge bool
+
+	sMaxAge    time.Duration
+	hasSMaxAge bool
+}
+
+func parseCacheControl(header string) cacheControl {
+	var cc cacheControl
+
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.TrimSpace(directive)
+
+		name := directive
+		value := ""
+
+		if i := strings.IndexByte(directive, '='); i >= 0 {
+			name = strings.TrimSpace(directive[:i])
+			value = strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+		}
+
+		switch strings.ToLower(name) {
+		case "no-store":
+			cc.noStore = true
+		case "no-cache":
+			cc.noCache = true
+		case "private":
+			cc.private = true
+		case "must-revalidate", "proxy-revalidate":
+			cc.mustRevalidate = true
+		case "max-age":
+			if secs, err := strconv.Atoi(value); err == nil {
+				cc.maxAge = time.Duration(secs) * time.Second
+				cc.hasMaxAge = true
+			}
+		case "s-maxage":
+			if secs, err := strconv.Atoi(value); err == nil {
+				cc.sMaxAge = time.Duration(secs) * time.Second
+				cc.hasSMaxAge = true
+			}
+		}
+	}
+
+	return cc
+}
+
+// freshnessTTL computes how long a response may be served without
+// revalidation, clamped to conf's min/max bounds. A no-cache or
+// must-revalidate response reports 0 without clamping, so it is
+// revalidated on every use. ok is false when the response isn't cacheable
+// at all.
+func freshnessTTL(conf Config, statusCode int, header http.Header) (ttl time.Duration, ok bool) {
+	cc := parseCacheControl(header.Get("Cache-Control"))
+
+	if cc.noStore || cc.private || varyIsWild(header) {
+		return 0, false
+	}
+
+	if statusCode >= 400 {
+		negTTL, hasNeg := conf.NegativeTTL[statusCode]
+		if !hasNeg {
+			return 0, false
+		}
+
+		return clampTTL(conf, negTTL), true
+	}
+
+	if strings.Contains(strings.ToLower(header.Get("Pragma")), "no-cache") {
+		cc.noCache = true
+	}
+
+	if cc.noCache || cc.mustRevalidate {
+		return 0, true
+	}
+
+	switch {
+	case cc.hasSMaxAge:
+		ttl = cc.sMaxAge
+	case cc.hasMaxAge:
+		ttl = cc.maxAge
+	case header.Get("Expires") != "":
+		if exp, err := http.ParseTime(header.Get("Expires")); err == nil {
+			ttl = time.Until(exp)
+		} else {
+			ttl = conf.DefaultTTL
+		}
+	default:
+		ttl = conf.DefaultTTL
+	}
+
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil {
+		ttl -= time.Duration(age) * time.Second
+	}
+
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return clampTTL(conf, ttl), true
+}
+
+// varyIsWild reports whether the response carries `Vary: *`, which means
+// it can never be correctly served from a cache keyed on URL alone.
+func varyIsWild(header http.Header) bool {
+	for _, v := range strings.Split(header.Get("Vary"), ",") {
+		if strings.TrimSpace(v) == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func clampTTL(conf Config, ttl time.Duration) time.Duration {
+	if conf.MinTTL > 0 && ttl < conf.MinTTL {
+		ttl = conf.MinTTL
+	}
+
+	if conf.MaxTTL > 0 && ttl > conf.MaxTTL {
+		ttl = conf.MaxTTL
+	}
+
+	return ttl
+}
@@ -0,0 +1,130 @@
+package sourcecache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(`no-cache, max-age=300, Private, s-maxage="120"`)
+
+	if !cc.noCache {
+		t.Error("expected noCache")
+	}
+	if !cc.private {
+		t.Error("expected private")
+	}
+	if !cc.hasMaxAge || cc.maxAge != 300*time.Second {
+		t.Errorf("expected maxAge=300s, got %v (hasMaxAge=%v)", cc.maxAge, cc.hasMaxAge)
+	}
+	if !cc.hasSMaxAge || cc.sMaxAge != 120*time.Second {
+		t.Errorf("expected sMaxAge=120s (quoted value), got %v (hasSMaxAge=%v)", cc.sMaxAge, cc.hasSMaxAge)
+	}
+}
+
+func TestVaryIsWild(t *testing.T) {
+	wild := http.Header{"Vary": []string{"Accept-Encoding, *"}}
+	if !varyIsWild(wild) {
+		t.Error("expected Vary: * to be detected among other values")
+	}
+
+	notWild := http.Header{"Vary": []string{"Accept-Encoding"}}
+	if varyIsWild(notWild) {
+		t.Error("did not expect Vary: Accept-Encoding to be wild")
+	}
+}
+
+func TestClampTTL(t *testing.T) {
+	conf := Config{MinTTL: 10 * time.Second, MaxTTL: time.Minute}
+
+	if got := clampTTL(conf, 0); got != conf.MinTTL {
+		t.Errorf("expected ttl clamped up to MinTTL, got %v", got)
+	}
+	if got := clampTTL(conf, time.Hour); got != conf.MaxTTL {
+		t.Errorf("expected ttl clamped down to MaxTTL, got %v", got)
+	}
+	if got := clampTTL(conf, 30*time.Second); got != 30*time.Second {
+		t.Errorf("expected ttl within bounds to pass through unchanged, got %v", got)
+	}
+}
+
+func TestFreshnessTTLNoStoreIsUncacheable(t *testing.T) {
+	header := http.Header{"Cache-Control": []string{"no-store"}}
+	if _, ok := freshnessTTL(Config{}, 200, header); ok {
+		t.Error("expected no-store response to be uncacheable")
+	}
+}
+
+func TestFreshnessTTLMustRevalidateIsZeroTTL(t *testing.T) {
+	header := http.Header{"Cache-Control": []string{"must-revalidate"}}
+	ttl, ok := freshnessTTL(Config{DefaultTTL: time.Hour}, 200, header)
+	if !ok {
+		t.Fatal("expected must-revalidate response to still be cacheable")
+	}
+	if ttl != 0 {
+		t.Errorf("expected must-revalidate to report ttl=0 so it's revalidated on every use, got %v", ttl)
+	}
+}
+
+func TestFreshnessTTLAgeIsSubtracted(t *testing.T) {
+	header := http.Header{
+		"Cache-Control": []string{"max-age=100"},
+		"Age":           []string{"40"},
+	}
+
+	ttl, ok := freshnessTTL(Config{}, 200, header)
+	if !ok {
+		t.Fatal("expected response to be cacheable")
+	}
+	if ttl != 60*time.Second {
+		t.Errorf("expected max-age minus Age, got %v", ttl)
+	}
+}
+
+func TestFreshnessTTLNegativeCaching(t *testing.T) {
+	conf := Config{NegativeTTL: map[int]time.Duration{404: 30 * time.Second}}
+
+	if ttl, ok := freshnessTTL(conf, 404, http.Header{}); !ok || ttl != 30*time.Second {
+		t.Errorf("expected configured negative TTL for 404, got ttl=%v ok=%v", ttl, ok)
+	}
+
+	if _, ok := freshnessTTL(conf, 500, http.Header{}); ok {
+		t.Error("expected a status with no configured negative TTL to be uncacheable")
+	}
+}
+
+func TestNewEntryRejectsVaryWild(t *testing.T) {
+	header := http.Header{"Vary": []string{"*"}}
+	if _, ok := NewEntry(Config{DefaultTTL: time.Minute}, 200, header, []byte("x")); ok {
+		t.Error("expected Vary: * response to be rejected")
+	}
+}
+
+func TestEntryRevalidatableAndConditionalHeaders(t *testing.T) {
+	e := &Entry{ETag: `"abc"`, LastModified: "Mon, 01 Jan 2024 00:00:00 GMT"}
+
+	if !e.Revalidatable() {
+		t.Fatal("expected entry with ETag/Last-Modified to be revalidatable")
+	}
+
+	h := e.ConditionalHeaders()
+	if h.Get("If-None-Match") != `"abc"` || h.Get("If-Modified-Since") != e.LastModified {
+		t.Errorf("unexpected conditional headers: %v", h)
+	}
+}
+
+func TestEntryRefreshExtendsDeadlineAndAdoptsHeaders(t *testing.T) {
+	e := &Entry{StatusCode: 200, ETag: `"old"`}
+	header := http.Header{"Cache-Control": []string{"max-age=60"}}
+	header.Set("ETag", `"new"`)
+
+	e.Refresh(Config{}, header)
+
+	if e.ETag != `"new"` {
+		t.Errorf("expected ETag to be adopted from the 304, got %q", e.ETag)
+	}
+	if !e.Fresh() {
+		t.Error("expected entry to be fresh after a refresh with a positive max-age")
+	}
+}
@@ -0,0 +1,52 @@
+package sourcecache
+
+// Tiered composes a fast L1 cache with a larger, possibly persistent L2
+// cache, promoting L2 hits into L1 so repeat lookups avoid the slower
+// tier. L2 may be nil, in which case Tiered behaves as L1 alone.
+type Tiered struct {
+	L1 SourceCache
+	L2 SourceCache
+}
+
+func NewTiered(l1, l2 SourceCache) *Tiered {
+	return &Tiered{L1: l1, L2: l2}
+}
+
+func (t *Tiered) Get(url string) (*Entry, bool) {
+	if e, ok := t.L1.Get(url); ok {
+		return e, true
+	}
+
+	if t.L2 == nil {
+		return nil, false
+	}
+
+	e, ok := t.L2.Get(url)
+	if ok {
+		t.L1.Put(url, e)
+	}
+
+	return e, ok
+}
+
+func (t *Tiered) Put(url string, e *Entry) {
+	t.L1.Put(url, e)
+
+	if t.L2 != nil {
+		t.L2.Put(url, e)
+	}
+}
+
+func (t *Tiered) Delete(url string) {
+	t.L1.Delete(url)
+
+	if t.L2 != nil {
+		t.L2.Delete(url)
+	}
+}
+
+// Stats reports L1 occupancy, which is what `/debug/cache` cares about
+// day to day; L2 has its own Stats() if an operator needs it directly.
+func (t *Tiered) Stats() Stats {
+	return t.L1.Stats()
+}
@@ -0,0 +1,41 @@
+package sourcecache
+
+import "testing"
+
+func TestTieredPromotesL2HitIntoL1(t *testing.T) {
+	l1 := NewMemoryCache(0)
+	l2 := NewMemoryCache(0)
+	tiered := NewTiered(l1, l2)
+
+	l2.Put("a", &Entry{Body: []byte("hello")})
+
+	if _, ok := l1.Get("a"); ok {
+		t.Fatal("precondition: l1 should not have the entry yet")
+	}
+
+	e, ok := tiered.Get("a")
+	if !ok || string(e.Body) != "hello" {
+		t.Fatalf("expected Tiered.Get to fall through to L2, got %v ok=%v", e, ok)
+	}
+
+	if _, ok := l1.Get("a"); !ok {
+		t.Fatal("expected an L2 hit to be promoted into L1")
+	}
+}
+
+func TestTieredWithNilL2(t *testing.T) {
+	l1 := NewMemoryCache(0)
+	tiered := NewTiered(l1, nil)
+
+	tiered.Put("a", &Entry{Body: []byte("hello")})
+
+	e, ok := tiered.Get("a")
+	if !ok || string(e.Body) != "hello" {
+		t.Fatalf("expected Tiered to behave as L1 alone when L2 is nil, got %v ok=%v", e, ok)
+	}
+
+	tiered.Delete("a")
+	if _, ok := tiered.Get("a"); ok {
+		t.Fatal("expected delete to propagate to L1 with a nil L2")
+	}
+}